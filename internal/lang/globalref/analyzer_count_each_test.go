@@ -0,0 +1,54 @@
+package globalref
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs"
+)
+
+func TestResolveCountEachRefs(t *testing.T) {
+	countRef := &addrs.Reference{Subject: addrs.CountAttr{Name: "index"}}
+	eachRef := &addrs.Reference{Subject: addrs.ForEachAttr{Name: "key"}}
+	otherRef := &addrs.Reference{Subject: addrs.InputVariable{Name: "unrelated"}}
+
+	rc := &configs.Resource{
+		Count:   fakeExpr{refTraversal("var", "count_source")},
+		ForEach: fakeExpr{refTraversal("var", "each_source")},
+	}
+
+	refs := resolveCountEachRefs([]*addrs.Reference{countRef, eachRef, otherRef}, rc)
+
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.Subject.String())
+	}
+
+	want := map[string]bool{
+		"var.count_source": true,
+		"var.each_source":  true,
+		"var.unrelated":    true,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d references %v, want %d", len(got), got, len(want))
+	}
+	for _, g := range got {
+		if !want[g] {
+			t.Errorf("unexpected reference %q", g)
+		}
+	}
+
+	// A resource with no "count" expression at all should pass a
+	// count.index reference through unresolved rather than silently
+	// dropping it.
+	bareRc := &configs.Resource{}
+	passthrough := resolveCountEachRefs([]*addrs.Reference{countRef}, bareRc)
+	if len(passthrough) != 1 || passthrough[0] != countRef {
+		t.Errorf("expected count.index reference to pass through unresolved when Count is nil, got %v", passthrough)
+	}
+
+	// A nil resource (no context available at all) should behave the same way.
+	if got := resolveCountEachRefs([]*addrs.Reference{countRef}, nil); len(got) != 1 || got[0] != countRef {
+		t.Errorf("expected passthrough for nil resource, got %v", got)
+	}
+}
@@ -1,8 +1,15 @@
 package globalref
 
 import (
+	"sort"
+
 	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/gocty"
+
 	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs"
+	"github.com/hashicorp/terraform/internal/configs/configschema"
 	"github.com/hashicorp/terraform/internal/lang"
 )
 
@@ -51,8 +58,24 @@ func (a *Analyzer) MetaReferences(moduleAddr addrs.ModuleInstance, ref *addrs.Re
 		return a.metaReferencesInputVariable(moduleAddr, targetAddr, ref.Remaining)
 	case addrs.AbsModuleCallOutput:
 		return a.metaReferencesOutputValue(moduleAddr, targetAddr, ref.Remaining)
+	case addrs.ModuleCallInstanceOutput:
+		return a.metaReferencesModuleCallInstanceOutput(moduleAddr, targetAddr, ref.Remaining)
+	case addrs.LocalValue:
+		return a.metaReferencesLocalValue(moduleAddr, targetAddr)
 	case addrs.ResourceInstance:
 		return a.metaReferencesResourceInstance(moduleAddr, targetAddr, ref.Remaining)
+	case addrs.CountAttr, addrs.ForEachAttr:
+		// "count.index" and "each.key"/"each.value" don't name a reusable
+		// configuration object the way everything else in this switch
+		// does: they're per-instance values scoped to whichever resource
+		// or module call declared the "count"/"for_each" expression that
+		// produced them, and that owning object isn't recoverable from
+		// the subject address alone. metaReferencesResourceInstance
+		// resolves these inline, substituting in the owning resource's
+		// own "count"/"for_each" expression, at the one point where it
+		// already has that context; there's nothing more we can do with
+		// just a bare moduleAddr and this subject.
+		return moduleAddr, nil
 	default:
 		// For anything we don't explicitly support we'll just return no
 		// references. This includes the reference types that don't really
@@ -105,7 +128,20 @@ func (a *Analyzer) metaReferencesInputVariable(calleeAddr addrs.ModuleInstance,
 }
 
 func (a *Analyzer) metaReferencesOutputValue(callerAddr addrs.ModuleInstance, addr addrs.AbsModuleCallOutput, remain hcl.Traversal) (addrs.ModuleInstance, []*addrs.Reference) {
-	calleeAddr := callerAddr.Child(addr.Call.Call.Name, addr.Call.Key)
+	return a.metaReferencesModuleOutput(callerAddr, addr.Call.Call.Name, addr.Call.Key, addr.Name)
+}
+
+func (a *Analyzer) metaReferencesModuleCallInstanceOutput(callerAddr addrs.ModuleInstance, addr addrs.ModuleCallInstanceOutput, remain hcl.Traversal) (addrs.ModuleInstance, []*addrs.Reference) {
+	return a.metaReferencesModuleOutput(callerAddr, addr.Call.Call.Name, addr.Call.Key, addr.Name)
+}
+
+// metaReferencesModuleOutput is the shared implementation behind both
+// addrs.AbsModuleCallOutput and addrs.ModuleCallInstanceOutput, which differ
+// only in how they were produced by the reference parser but otherwise both
+// identify a single output value belonging to a single instance of a module
+// call.
+func (a *Analyzer) metaReferencesModuleOutput(callerAddr addrs.ModuleInstance, callName string, callKey addrs.InstanceKey, outputName string) (addrs.ModuleInstance, []*addrs.Reference) {
+	calleeAddr := callerAddr.Child(callName, callKey)
 
 	// We need to find the output value declaration inside the callee module.
 	calleeCfg := a.ModuleConfig(calleeAddr)
@@ -113,15 +149,88 @@ func (a *Analyzer) metaReferencesOutputValue(callerAddr addrs.ModuleInstance, ad
 		return calleeAddr, nil
 	}
 
-	oc := calleeCfg.Outputs[addr.Name]
+	return calleeAddr, outputValueReferences(calleeCfg, outputName)
+}
+
+// outputValueReferences returns the references contained in the expression
+// of the output value named outputName in modCfg, or nil if modCfg doesn't
+// declare an output by that name.
+func outputValueReferences(modCfg *configs.Module, outputName string) []*addrs.Reference {
+	oc := modCfg.Outputs[outputName]
 	if oc == nil {
-		return calleeAddr, nil
+		return nil
 	}
 
 	// We don't check for errors here because we'll make a best effort to
 	// analyze whatever partial result HCL is able to extract.
 	refs, _ := lang.ReferencesInExpr(oc.Expr)
-	return calleeAddr, refs
+	return refs
+}
+
+// ModuleCallInstanceSelectorReferences returns the references contained in
+// the "count" or "for_each" expression (whichever is set) of the module
+// call named callName inside the module identified by callerAddr.
+//
+// These references determine which -- and how many -- instances of the
+// call exist, so they're one of the things that can indirectly affect the
+// value of anything retrieved from one of those instances, such as an
+// output value reached via MetaReferences. Unlike MetaReferences itself,
+// the references this returns are always relative to callerAddr, the same
+// module instance that was passed in, never to the callee: that's what
+// lets callers combine them correctly with the callee-relative references
+// MetaReferences returns for a module call's output value, instead of
+// conflating two different module instances' references into one slice as
+// if they shared an address.
+func (a *Analyzer) ModuleCallInstanceSelectorReferences(callerAddr addrs.ModuleInstance, callName string) []*addrs.Reference {
+	callerCfg := a.ModuleConfig(callerAddr)
+	if callerCfg == nil {
+		return nil
+	}
+	return moduleCallSelectorReferences(callerCfg, callName)
+}
+
+// moduleCallSelectorReferences returns the references contained in the
+// "count" or "for_each" expression (whichever is set) of the module call
+// named callName in callerCfg, or nil if callerCfg has no module call by
+// that name.
+func moduleCallSelectorReferences(callerCfg *configs.Module, callName string) []*addrs.Reference {
+	call := callerCfg.ModuleCalls[callName]
+	if call == nil {
+		return nil
+	}
+
+	var refs []*addrs.Reference
+	if call.Count != nil {
+		countRefs, _ := lang.ReferencesInExpr(call.Count)
+		refs = append(refs, countRefs...)
+	}
+	if call.ForEach != nil {
+		forEachRefs, _ := lang.ReferencesInExpr(call.ForEach)
+		refs = append(refs, forEachRefs...)
+	}
+	return refs
+}
+
+func (a *Analyzer) metaReferencesLocalValue(moduleAddr addrs.ModuleInstance, addr addrs.LocalValue) (addrs.ModuleInstance, []*addrs.Reference) {
+	modCfg := a.ModuleConfig(moduleAddr)
+	if modCfg == nil {
+		return moduleAddr, nil
+	}
+
+	return moduleAddr, localValueReferences(modCfg, addr)
+}
+
+// localValueReferences returns the references contained in the expression
+// of the local value named addr.Name in modCfg, or nil if modCfg doesn't
+// declare a local value by that name.
+func localValueReferences(modCfg *configs.Module, addr addrs.LocalValue) []*addrs.Reference {
+	local := modCfg.Locals[addr.Name]
+	if local == nil {
+		return nil
+	}
+
+	refs, _ := lang.ReferencesInExpr(local.Expr)
+	return refs
 }
 
 func (a *Analyzer) metaReferencesResourceInstance(moduleAddr addrs.ModuleInstance, addr addrs.ResourceInstance, remain hcl.Traversal) (addrs.ModuleInstance, []*addrs.Reference) {
@@ -148,15 +257,232 @@ func (a *Analyzer) metaReferencesResourceInstance(moduleAddr addrs.ModuleInstanc
 		return moduleAddr, nil
 	}
 
-	// When analyzing the resource configuration to look for references, we'll
-	// make a best effort to narrow down to only a particular sub-portion of
-	// the configuration by following the remaining traversal steps. In the
-	// ideal case this will lead us to a specific expression, but as a
-	// compromise it might lead us to a nested block where we can then
-	// analyze _all_ of the expressions inside.
-	body := rc.Config
-	schema := resourceTypeSchema
-	for _, step := range remain {
+	return moduleAddr, narrowResourceReferences(rc.Config, resourceTypeSchema, remain, rc)
+}
+
+// narrowResourceReferences is the part of metaReferencesResourceInstance
+// that, given the resource's own configuration body and schema, makes a
+// best effort to narrow down to only a particular sub-portion of that
+// configuration by following the remaining traversal steps. In the ideal
+// case this will lead us to a specific expression, but as a compromise it
+// might lead us to a nested block where we can then analyze _all_ of the
+// expressions inside.
+//
+// This is factored out from metaReferencesResourceInstance, rather than
+// being inlined there, so that it can be exercised directly without also
+// needing an Analyzer and a provider schema: everything it needs is
+// already resolved by its caller.
+func narrowResourceReferences(body hcl.Body, schema *configschema.Block, remain hcl.Traversal, rc *configs.Resource) []*addrs.Reference {
+	for i := 0; i < len(remain); i++ {
+		step, ok := remain[i].(hcl.TraverseAttr)
+		if !ok {
+			// An index step that isn't immediately preceded by a block
+			// type name (handled below, as part of that name's step)
+			// doesn't make sense to us, so we can't narrow down any
+			// further than what we already have.
+			return referencesInBody(body, schema, rc)
+		}
+
+		if _, ok := schema.Attributes[step.Name]; ok {
+			content, _, _ := body.PartialContent(&hcl.BodySchema{
+				Attributes: []hcl.AttributeSchema{{Name: step.Name}},
+			})
+			attr := content.Attributes[step.Name]
+			if attr == nil {
+				// The traversal refers to an attribute that isn't actually
+				// set in the configuration, so there's nothing more
+				// specific we can return than what we already have.
+				return referencesInBody(body, schema, rc)
+			}
+			refs, _ := lang.ReferencesInExpr(attr.Expr)
+			return resolveCountEachRefs(refs, rc)
+		}
+
+		blockS, ok := schema.BlockTypes[step.Name]
+		if !ok {
+			// Not a real attribute or nested block type in this schema, so
+			// the traversal doesn't refer to anything that actually exists.
+			return referencesInBody(body, schema, rc)
+		}
+
+		content, _, _ := body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: step.Name}},
+		})
+		var blocks []*hcl.Block
+		for _, block := range content.Blocks {
+			if block.Type == step.Name {
+				blocks = append(blocks, block)
+			}
+		}
+		if len(blocks) == 0 {
+			return referencesInBody(body, schema, rc)
+		}
+
+		switch blockS.Nesting {
+		case configschema.NestingSingle, configschema.NestingGroup:
+			body, schema = blocks[0].Body, &blockS.Block
+			continue
+
+		case configschema.NestingList, configschema.NestingSet:
+			if idx, ok := indexStepAfter(remain, i); ok {
+				var iv int
+				if err := gocty.FromCtyValue(idx, &iv); err == nil && iv >= 0 && iv < len(blocks) {
+					body, schema = blocks[iv].Body, &blockS.Block
+					i++ // also consume the index step
+					continue
+				}
+			}
+			// We can't resolve down to a single instance of this nested
+			// block -- either there was no index step, or it was a dynamic
+			// value we can't evaluate -- so as a compromise we'll return
+			// the union of the references from all of the instances.
+			var refs []*addrs.Reference
+			for _, block := range blocks {
+				refs = append(refs, referencesInBody(block.Body, &blockS.Block, rc)...)
+			}
+			return refs
+
+		case configschema.NestingMap:
+			if idx, ok := indexStepAfter(remain, i); ok && idx.Type() == cty.String {
+				key := idx.AsString()
+				found := false
+				for _, block := range blocks {
+					if len(block.Labels) > 0 && block.Labels[0] == key {
+						body, schema = block.Body, &blockS.Block
+						found = true
+						break
+					}
+				}
+				if found {
+					i++ // also consume the index step
+					continue
+				}
+			}
+			var refs []*addrs.Reference
+			for _, block := range blocks {
+				refs = append(refs, referencesInBody(block.Body, &blockS.Block, rc)...)
+			}
+			return refs
+
+		default:
+			// Shouldn't get here, but we'll be robust about it and just
+			// return the union of everything we can find.
+			var refs []*addrs.Reference
+			for _, block := range blocks {
+				refs = append(refs, referencesInBody(block.Body, &blockS.Block, rc)...)
+			}
+			return refs
+		}
+	}
+
+	// If we ran out of traversal steps then "body"/"schema" refer to the
+	// widest subtree we managed to narrow down to, so we'll return the
+	// union of all of the references inside it.
+	return referencesInBody(body, schema, rc)
+}
+
+// indexStepAfter returns the index key from the hcl.TraverseIndex step
+// immediately following position i in traversal, if there is one.
+func indexStepAfter(traversal hcl.Traversal, i int) (cty.Value, bool) {
+	if i+1 >= len(traversal) {
+		return cty.NilVal, false
+	}
+	idxStep, ok := traversal[i+1].(hcl.TraverseIndex)
+	if !ok {
+		return cty.NilVal, false
+	}
+	return idxStep.Key, true
+}
+
+// referencesInBody returns the union of the references contained in all of
+// the expressions in the given body, as constrained by the given schema,
+// including the expressions inside any nested blocks.
+//
+// rc is the resource that body ultimately belongs to (whether body is the
+// resource's own top-level config or the body of one of its nested blocks):
+// it's used to resolve any "count.index"/"each.key" references we find
+// along the way, which refer back to that resource's own "count"/"for_each"
+// expression rather than to anything reachable through the schema.
+//
+// This is the fallback behavior for when a traversal doesn't manage to
+// narrow down to one specific attribute: we report every reference that
+// could possibly be relevant, to err on the side of over-reporting rather
+// than silently dropping a real dependency.
+func referencesInBody(body hcl.Body, schema *configschema.Block, rc *configs.Resource) []*addrs.Reference {
+	if body == nil || schema == nil {
+		return nil
+	}
+
+	attrNames := make([]string, 0, len(schema.Attributes))
+	for name := range schema.Attributes {
+		attrNames = append(attrNames, name)
+	}
+	sort.Strings(attrNames)
+	blockNames := make([]string, 0, len(schema.BlockTypes))
+	for name := range schema.BlockTypes {
+		blockNames = append(blockNames, name)
+	}
+	sort.Strings(blockNames)
+
+	var bodySchema hcl.BodySchema
+	for _, name := range attrNames {
+		bodySchema.Attributes = append(bodySchema.Attributes, hcl.AttributeSchema{Name: name})
+	}
+	for _, name := range blockNames {
+		bodySchema.Blocks = append(bodySchema.Blocks, hcl.BlockHeaderSchema{Type: name})
+	}
+
+	content, _, _ := body.PartialContent(&bodySchema)
+
+	var refs []*addrs.Reference
+	for _, name := range attrNames {
+		attr, ok := content.Attributes[name]
+		if !ok {
+			continue
+		}
+		attrRefs, _ := lang.ReferencesInExpr(attr.Expr)
+		refs = append(refs, resolveCountEachRefs(attrRefs, rc)...)
+	}
+	for _, block := range content.Blocks {
+		blockS, ok := schema.BlockTypes[block.Type]
+		if !ok {
+			continue
+		}
+		refs = append(refs, referencesInBody(block.Body, &blockS.Block, rc)...)
+	}
+
+	return refs
+}
+
+// resolveCountEachRefs replaces any addrs.CountAttr or addrs.ForEachAttr
+// reference in refs with the references contained in rc's own "count" or
+// "for_each" expression (whichever applies), since those are the only
+// place a resource's "count.index" or "each.key" usage can actually be
+// traced back to. References of any other kind pass through unchanged, as
+// does a count/each reference when rc is nil or doesn't set the matching
+// "count"/"for_each" expression.
+func resolveCountEachRefs(refs []*addrs.Reference, rc *configs.Resource) []*addrs.Reference {
+	if rc == nil {
+		return refs
+	}
 
+	ret := make([]*addrs.Reference, 0, len(refs))
+	for _, ref := range refs {
+		switch ref.Subject.(type) {
+		case addrs.CountAttr:
+			if rc.Count != nil {
+				countRefs, _ := lang.ReferencesInExpr(rc.Count)
+				ret = append(ret, countRefs...)
+				continue
+			}
+		case addrs.ForEachAttr:
+			if rc.ForEach != nil {
+				forEachRefs, _ := lang.ReferencesInExpr(rc.ForEach)
+				ret = append(ret, forEachRefs...)
+				continue
+			}
+		}
+		ret = append(ret, ref)
 	}
+	return ret
 }
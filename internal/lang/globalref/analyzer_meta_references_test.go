@@ -0,0 +1,311 @@
+package globalref
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/gocty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs/configschema"
+)
+
+// fakeExpr is a minimal hcl.Expression that reports a single fixed
+// traversal as its only variable reference, which is all referencesInBody
+// needs in order to exercise lang.ReferencesInExpr.
+type fakeExpr struct {
+	traversal hcl.Traversal
+}
+
+func (e fakeExpr) Value(ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+	return cty.DynamicVal, nil
+}
+
+func (e fakeExpr) Variables() []hcl.Traversal {
+	return []hcl.Traversal{e.traversal}
+}
+
+func (e fakeExpr) Range() hcl.Range {
+	return hcl.Range{}
+}
+
+func refTraversal(names ...string) hcl.Traversal {
+	t := make(hcl.Traversal, 0, len(names))
+	for i, name := range names {
+		if i == 0 {
+			t = append(t, hcl.TraverseRoot{Name: name})
+		} else {
+			t = append(t, hcl.TraverseAttr{Name: name})
+		}
+	}
+	return t
+}
+
+// fakeBody is a minimal hcl.Body that serves up a fixed set of attributes
+// and nested blocks, without needing a real HCL parser, so that
+// referencesInBody's schema-driven walk can be exercised directly.
+type fakeBody struct {
+	attrs  map[string]hcl.Expression
+	blocks []fakeBlock
+}
+
+type fakeBlock struct {
+	typeName string
+	labels   []string
+	body     hcl.Body
+}
+
+func (b *fakeBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	content, _, diags := b.PartialContent(schema)
+	return content, diags
+}
+
+func (b *fakeBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	content := &hcl.BodyContent{
+		Attributes: map[string]*hcl.Attribute{},
+	}
+	for _, as := range schema.Attributes {
+		if expr, ok := b.attrs[as.Name]; ok {
+			content.Attributes[as.Name] = &hcl.Attribute{Name: as.Name, Expr: expr}
+		}
+	}
+	for _, bs := range schema.Blocks {
+		for _, blk := range b.blocks {
+			if blk.typeName == bs.Type {
+				content.Blocks = append(content.Blocks, &hcl.Block{
+					Type:   blk.typeName,
+					Labels: blk.labels,
+					Body:   blk.body,
+				})
+			}
+		}
+	}
+	return content, b, nil
+}
+
+func (b *fakeBody) JustAttributes() (hcl.Attributes, hcl.Diagnostics) {
+	return nil, nil
+}
+
+func (b *fakeBody) MissingItemRange() hcl.Range {
+	return hcl.Range{}
+}
+
+func TestReferencesInBody_NestedBlockNesting(t *testing.T) {
+	nestedSchema := configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"value": {Type: cty.String, Optional: true},
+		},
+	}
+
+	schema := &configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"direct": {Type: cty.String, Optional: true},
+		},
+		BlockTypes: map[string]*configschema.NestedBlock{
+			"single_item": {Nesting: configschema.NestingSingle, Block: nestedSchema},
+			"list_item":   {Nesting: configschema.NestingList, Block: nestedSchema},
+			"set_item":    {Nesting: configschema.NestingSet, Block: nestedSchema},
+			"map_item":    {Nesting: configschema.NestingMap, Block: nestedSchema},
+		},
+	}
+
+	body := &fakeBody{
+		attrs: map[string]hcl.Expression{
+			"direct": fakeExpr{refTraversal("var", "direct")},
+		},
+		blocks: []fakeBlock{
+			{typeName: "single_item", body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "single")}},
+			}},
+			{typeName: "list_item", body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "list0")}},
+			}},
+			{typeName: "list_item", body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "list1")}},
+			}},
+			{typeName: "set_item", body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "set0")}},
+			}},
+			{typeName: "map_item", labels: []string{"k"}, body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "mapk")}},
+			}},
+		},
+	}
+
+	// referencesInBody must return references in a deterministic order --
+	// attributes and block types sorted by name, not whatever order the
+	// underlying schema maps happen to iterate in -- since that order
+	// ultimately feeds into ReferencesFromOutputsWithMaxDepth's BFS and
+	// needs to be stable across repeated calls with the same config. We
+	// deliberately don't sort "got" before comparing: if referencesInBody
+	// regresses to iterating schema.Attributes/schema.BlockTypes directly,
+	// this assertion should start flaking instead of silently passing.
+	refs := referencesInBody(body, schema, nil)
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.Subject.String())
+	}
+
+	want := []string{"var.direct", "var.list0", "var.list1", "var.mapk", "var.set0", "var.single"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d references %v, want %d: %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("reference %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNarrowResourceReferences(t *testing.T) {
+	nestedSchema := configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"value": {Type: cty.String, Optional: true},
+		},
+	}
+
+	schema := &configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"direct": {Type: cty.String, Optional: true},
+		},
+		BlockTypes: map[string]*configschema.NestedBlock{
+			"list_item": {Nesting: configschema.NestingList, Block: nestedSchema},
+			"map_item":  {Nesting: configschema.NestingMap, Block: nestedSchema},
+		},
+	}
+
+	body := &fakeBody{
+		attrs: map[string]hcl.Expression{
+			"direct": fakeExpr{refTraversal("var", "direct")},
+		},
+		blocks: []fakeBlock{
+			{typeName: "list_item", body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "list0")}},
+			}},
+			{typeName: "list_item", body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "list1")}},
+			}},
+			{typeName: "map_item", labels: []string{"k1"}, body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "mapk1")}},
+			}},
+			{typeName: "map_item", labels: []string{"k2"}, body: &fakeBody{
+				attrs: map[string]hcl.Expression{"value": fakeExpr{refTraversal("var", "mapk2")}},
+			}},
+		},
+	}
+
+	subjectRefs := func(refs []*addrs.Reference) []string {
+		var got []string
+		for _, ref := range refs {
+			got = append(got, ref.Subject.String())
+		}
+		return got
+	}
+
+	t.Run("direct attribute", func(t *testing.T) {
+		remain := hcl.Traversal{hcl.TraverseAttr{Name: "direct"}}
+		refs := narrowResourceReferences(body, schema, remain, nil)
+		got := subjectRefs(refs)
+		if len(got) != 1 || got[0] != "var.direct" {
+			t.Errorf("got %v, want only [var.direct]", got)
+		}
+	})
+
+	t.Run("indexed list element", func(t *testing.T) {
+		remain := hcl.Traversal{
+			hcl.TraverseAttr{Name: "list_item"},
+			hcl.TraverseIndex{Key: cty.NumberIntVal(1)},
+			hcl.TraverseAttr{Name: "value"},
+		}
+		refs := narrowResourceReferences(body, schema, remain, nil)
+		got := subjectRefs(refs)
+		if len(got) != 1 || got[0] != "var.list1" {
+			t.Errorf("got %v, want only [var.list1], not the whole list union", got)
+		}
+	})
+
+	t.Run("un-indexed list falls back to the union", func(t *testing.T) {
+		remain := hcl.Traversal{hcl.TraverseAttr{Name: "list_item"}}
+		refs := narrowResourceReferences(body, schema, remain, nil)
+		got := subjectRefs(refs)
+		want := map[string]bool{"var.list0": true, "var.list1": true}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want the union %v", got, want)
+		}
+		for _, g := range got {
+			if !want[g] {
+				t.Errorf("unexpected reference %q in union fallback", g)
+			}
+		}
+	})
+
+	t.Run("keyed map element", func(t *testing.T) {
+		remain := hcl.Traversal{
+			hcl.TraverseAttr{Name: "map_item"},
+			hcl.TraverseIndex{Key: cty.StringVal("k2")},
+			hcl.TraverseAttr{Name: "value"},
+		}
+		refs := narrowResourceReferences(body, schema, remain, nil)
+		got := subjectRefs(refs)
+		if len(got) != 1 || got[0] != "var.mapk2" {
+			t.Errorf("got %v, want only [var.mapk2]", got)
+		}
+	})
+
+	t.Run("unknown map key falls back to the union", func(t *testing.T) {
+		remain := hcl.Traversal{
+			hcl.TraverseAttr{Name: "map_item"},
+			hcl.TraverseIndex{Key: cty.StringVal("missing")},
+			hcl.TraverseAttr{Name: "value"},
+		}
+		refs := narrowResourceReferences(body, schema, remain, nil)
+		got := subjectRefs(refs)
+		want := map[string]bool{"var.mapk1": true, "var.mapk2": true}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want the union %v", got, want)
+		}
+		for _, g := range got {
+			if !want[g] {
+				t.Errorf("unexpected reference %q in union fallback", g)
+			}
+		}
+	})
+
+	t.Run("no traversal steps narrows nothing", func(t *testing.T) {
+		refs := narrowResourceReferences(body, schema, nil, nil)
+		got := subjectRefs(refs)
+		if len(got) != 5 {
+			t.Errorf("got %d refs %v, want the full union of 5", len(got), got)
+		}
+	})
+}
+
+func TestIndexStepAfter(t *testing.T) {
+	traversal := hcl.Traversal{
+		hcl.TraverseRoot{Name: "thing"},
+		hcl.TraverseAttr{Name: "list_item"},
+		hcl.TraverseIndex{Key: cty.NumberIntVal(1)},
+		hcl.TraverseAttr{Name: "value"},
+	}
+
+	idx, ok := indexStepAfter(traversal, 1)
+	if !ok {
+		t.Fatal("expected an index step after position 1")
+	}
+	var iv int
+	if err := gocty.FromCtyValue(idx, &iv); err != nil {
+		t.Fatalf("unexpected error converting index: %s", err)
+	}
+	if iv != 1 {
+		t.Errorf("got index %d, want 1", iv)
+	}
+
+	if _, ok := indexStepAfter(traversal, 2); ok {
+		t.Error("expected no index step immediately after the index step itself")
+	}
+	if _, ok := indexStepAfter(traversal, 10); ok {
+		t.Error("expected no index step past the end of the traversal")
+	}
+}
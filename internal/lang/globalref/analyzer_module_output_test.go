@@ -0,0 +1,72 @@
+package globalref
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs"
+)
+
+func TestOutputValueReferences(t *testing.T) {
+	modCfg := &configs.Module{
+		Outputs: map[string]*configs.Output{
+			"result": {Expr: fakeExpr{refTraversal("var", "result_source")}},
+		},
+	}
+
+	refs := outputValueReferences(modCfg, "result")
+	if len(refs) != 1 || refs[0].Subject.String() != "var.result_source" {
+		t.Fatalf("got %v, want a single reference to var.result_source", refs)
+	}
+
+	if refs := outputValueReferences(modCfg, "nonexistent"); refs != nil {
+		t.Errorf("got %v, want nil for an output that doesn't exist", refs)
+	}
+}
+
+func TestModuleCallSelectorReferences(t *testing.T) {
+	callerCfg := &configs.Module{
+		ModuleCalls: map[string]*configs.ModuleCall{
+			"count_call":    {Count: fakeExpr{refTraversal("var", "instance_count")}},
+			"for_each_call": {ForEach: fakeExpr{refTraversal("var", "instances")}},
+			"plain_call":    {},
+		},
+	}
+
+	refs := moduleCallSelectorReferences(callerCfg, "count_call")
+	if len(refs) != 1 || refs[0].Subject.String() != "var.instance_count" {
+		t.Errorf("got %v, want a single reference to var.instance_count", refs)
+	}
+
+	refs = moduleCallSelectorReferences(callerCfg, "for_each_call")
+	if len(refs) != 1 || refs[0].Subject.String() != "var.instances" {
+		t.Errorf("got %v, want a single reference to var.instances", refs)
+	}
+
+	if refs := moduleCallSelectorReferences(callerCfg, "plain_call"); refs != nil {
+		t.Errorf("got %v, want nil for a call with neither count nor for_each", refs)
+	}
+
+	if refs := moduleCallSelectorReferences(callerCfg, "nonexistent"); refs != nil {
+		t.Errorf("got %v, want nil for a call that doesn't exist", refs)
+	}
+}
+
+func TestLocalValueReferences(t *testing.T) {
+	modCfg := &configs.Module{
+		Locals: map[string]*configs.Local{
+			"combined": {Expr: fakeExpr{refTraversal("var", "a")}},
+		},
+	}
+
+	addr := addrs.LocalValue{Name: "combined"}
+	refs := localValueReferences(modCfg, addr)
+	if len(refs) != 1 || refs[0].Subject.String() != "var.a" {
+		t.Fatalf("got %v, want a single reference to var.a", refs)
+	}
+
+	missing := addrs.LocalValue{Name: "nonexistent"}
+	if refs := localValueReferences(modCfg, missing); refs != nil {
+		t.Errorf("got %v, want nil for a local value that doesn't exist", refs)
+	}
+}
@@ -0,0 +1,222 @@
+package globalref
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+// DefaultMaxReferenceChainDepth is the maximum number of hops
+// ReferencesFromOutputs will follow along any single chain before giving up
+// on it, to guard against pathological configurations -- such as a
+// deeply-nested chain of modules -- causing this function to run for an
+// unreasonable amount of time.
+const DefaultMaxReferenceChainDepth = 1024
+
+// ReferenceChain describes one path by which the value of some resource
+// instance or input variable flows, directly or indirectly, into a
+// particular output value, as found by ReferencesFromOutputs.
+type ReferenceChain struct {
+	// TargetModule is the module instance that Target belongs to.
+	TargetModule addrs.ModuleInstance
+
+	// Target is the resource instance or input variable that this chain
+	// says contributes to the requested output value.
+	Target addrs.Referenceable
+
+	// Steps records each reference that was followed to get from the
+	// requested output value to Target, in order. The first element is a
+	// reference found directly in the output's own expression, and the
+	// last element is a reference directly to Target. Callers can use
+	// this, alongside TargetModule and the module instance each step
+	// belongs to, to render a chain such as
+	// "output.foo -> module.x.output.bar -> aws_s3_bucket.b.arn".
+	Steps []*addrs.Reference
+}
+
+// ReferencesFromOutputs finds the full transitive set of resource instances
+// and input variables that contribute, directly or indirectly, to the value
+// of the given output value, by repeatedly applying MetaReferences and
+// following the resulting references across module boundaries.
+//
+// The given address can refer to either a root output value or one nested
+// inside a module instance. The result is deduplicated so that each
+// contributing resource instance or input variable appears only once, even
+// when it's reachable by more than one path; which of the (potentially
+// many) equivalent paths ends up attached to a particular result element is
+// undefined.
+//
+// This function guards against both reference cycles and pathologically
+// deep reference chains using DefaultMaxReferenceChainDepth. Use
+// ReferencesFromOutputsWithMaxDepth to override that limit.
+func (a *Analyzer) ReferencesFromOutputs(addr addrs.AbsOutputValue) []*ReferenceChain {
+	return a.ReferencesFromOutputsWithMaxDepth(addr, DefaultMaxReferenceChainDepth)
+}
+
+// ReferencesFromOutputsWithMaxDepth is a variant of ReferencesFromOutputs
+// that allows overriding the default maximum chain depth, for callers that
+// need to analyze unusually deep configurations or want a tighter bound.
+func (a *Analyzer) ReferencesFromOutputsWithMaxDepth(addr addrs.AbsOutputValue, maxDepth int) []*ReferenceChain {
+	modCfg := a.ModuleConfig(addr.Module)
+	if modCfg == nil {
+		return nil
+	}
+
+	rootRefs := outputValueReferences(modCfg, addr.OutputValue.Name)
+	return referencesFromOutputs(a, addr.Module, rootRefs, maxDepth)
+}
+
+// referenceResolver is the subset of *Analyzer's behavior that the BFS in
+// referencesFromOutputs depends on, factored out as an interface so that
+// the traversal, deduplication, and cycle-detection logic can be exercised
+// directly in tests against a fake implementation, without needing a real
+// Analyzer and the module configuration and provider schemas it requires.
+type referenceResolver interface {
+	MetaReferences(moduleAddr addrs.ModuleInstance, ref *addrs.Reference) (addrs.ModuleInstance, []*addrs.Reference)
+	ModuleCallInstanceSelectorReferences(callerAddr addrs.ModuleInstance, callName string) []*addrs.Reference
+}
+
+// referencesFromOutputs is the shared BFS implementation behind
+// ReferencesFromOutputsWithMaxDepth, starting from the given root module
+// instance and the references found directly in the requested output
+// value's own expression.
+func referencesFromOutputs(r referenceResolver, rootModuleAddr addrs.ModuleInstance, rootRefs []*addrs.Reference, maxDepth int) []*ReferenceChain {
+	queue := make([]*referenceChainFrontier, 0, len(rootRefs))
+	for _, ref := range rootRefs {
+		queue = append(queue, &referenceChainFrontier{
+			moduleAddr: rootModuleAddr,
+			ref:        ref,
+			steps:      []*addrs.Reference{ref},
+			depth:      1,
+		})
+	}
+
+	visited := make(map[string]struct{})
+	reported := make(map[string]struct{})
+	var ret []*ReferenceChain
+
+	for len(queue) > 0 {
+		entry := queue[0]
+		queue = queue[1:]
+
+		key := entry.visitKey()
+		if _, ok := visited[key]; ok {
+			// Either a reference cycle, or just another path that arrived
+			// at the same place we already explored from.
+			continue
+		}
+		visited[key] = struct{}{}
+
+		if entry.depth > maxDepth {
+			continue
+		}
+
+		switch entry.ref.Subject.(type) {
+		case addrs.ResourceInstance, addrs.InputVariable:
+			reportKey := fmt.Sprintf("%s|%s", entry.moduleAddr.String(), entry.ref.Subject.String())
+			if _, ok := reported[reportKey]; !ok {
+				reported[reportKey] = struct{}{}
+				ret = append(ret, &ReferenceChain{
+					TargetModule: entry.moduleAddr,
+					Target:       entry.ref.Subject,
+					Steps:        entry.steps,
+				})
+			}
+		}
+
+		// Regardless of what kind of thing this reference pointed at, we
+		// keep following it: a resource instance's own configuration can
+		// refer to other resource instances, and an input variable's
+		// caller-side expression can too, so we need to keep walking to
+		// find the full transitive set.
+		nextModuleAddr, nextRefs := r.MetaReferences(entry.moduleAddr, entry.ref)
+		enqueue := func(moduleAddr addrs.ModuleInstance, ref *addrs.Reference) {
+			nextSteps := make([]*addrs.Reference, len(entry.steps), len(entry.steps)+1)
+			copy(nextSteps, entry.steps)
+			nextSteps = append(nextSteps, ref)
+			queue = append(queue, &referenceChainFrontier{
+				moduleAddr: moduleAddr,
+				ref:        ref,
+				steps:      nextSteps,
+				depth:      entry.depth + 1,
+			})
+		}
+		for _, nextRef := range nextRefs {
+			enqueue(nextModuleAddr, nextRef)
+		}
+
+		// MetaReferences only returns references relative to a single
+		// module instance, namely the callee for a module call's output
+		// value -- but which instance of that call we resolved also
+		// depends on its own "count"/"for_each" expression, which is
+		// scoped to the *caller* instead. We can't fold those into
+		// nextRefs above without mislabeling their module instance, so we
+		// fetch and enqueue them separately, against entry.moduleAddr
+		// (the caller).
+		var callName string
+		switch subject := entry.ref.Subject.(type) {
+		case addrs.AbsModuleCallOutput:
+			callName = subject.Call.Call.Name
+		case addrs.ModuleCallInstanceOutput:
+			callName = subject.Call.Call.Name
+		}
+		if callName != "" {
+			for _, selectorRef := range r.ModuleCallInstanceSelectorReferences(entry.moduleAddr, callName) {
+				enqueue(entry.moduleAddr, selectorRef)
+			}
+		}
+	}
+
+	return ret
+}
+
+// referenceChainFrontier is one entry in the breadth-first search queue
+// used by ReferencesFromOutputsWithMaxDepth.
+type referenceChainFrontier struct {
+	moduleAddr addrs.ModuleInstance
+	ref        *addrs.Reference
+	steps      []*addrs.Reference
+	depth      int
+}
+
+// visitKey returns a string that uniquely identifies this frontier entry's
+// (module instance, subject, remaining traversal) triple, for use as a
+// cycle-detection key.
+func (f *referenceChainFrontier) visitKey() string {
+	return fmt.Sprintf("%s|%s|%s", f.moduleAddr.String(), f.ref.Subject.String(), traversalKey(f.ref.Remaining))
+}
+
+// traversalKey renders the steps of a traversal -- attribute names and
+// index keys, not source positions -- into a string suitable for use in a
+// map key. Two traversals with the same steps always produce the same
+// key, including the common case of an empty traversal (a bare reference
+// like "var.x" or "local.y"), regardless of whether the underlying HCL
+// carries meaningful source ranges.
+func traversalKey(t hcl.Traversal) string {
+	var sb strings.Builder
+	for _, step := range t {
+		switch step := step.(type) {
+		case hcl.TraverseAttr:
+			sb.WriteString(".")
+			sb.WriteString(step.Name)
+		case hcl.TraverseIndex:
+			sb.WriteString("[")
+			switch step.Key.Type() {
+			case cty.String:
+				sb.WriteString(step.Key.AsString())
+			case cty.Number:
+				sb.WriteString(step.Key.AsBigFloat().Text('f', -1))
+			default:
+				sb.WriteString(fmt.Sprintf("%#v", step.Key))
+			}
+			sb.WriteString("]")
+		case hcl.TraverseRoot:
+			sb.WriteString(step.Name)
+		}
+	}
+	return sb.String()
+}
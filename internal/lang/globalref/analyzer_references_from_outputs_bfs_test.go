@@ -0,0 +1,175 @@
+package globalref
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+// fakeResolver is a referenceResolver driven entirely by canned responses,
+// so that referencesFromOutputs's BFS, deduplication, and cycle-detection
+// logic can be exercised without a real Analyzer and the module
+// configuration and provider schemas it would otherwise require.
+type fakeResolver struct {
+	metaReferences     map[string]fakeMetaReferencesResult
+	metaReferenceCalls map[string]int
+	selectorReferences map[string][]*addrs.Reference
+}
+
+type fakeMetaReferencesResult struct {
+	moduleAddr addrs.ModuleInstance
+	refs       []*addrs.Reference
+}
+
+func metaReferencesKey(moduleAddr addrs.ModuleInstance, ref *addrs.Reference) string {
+	return fmt.Sprintf("%s|%s", moduleAddr.String(), ref.Subject.String())
+}
+
+func (f *fakeResolver) MetaReferences(moduleAddr addrs.ModuleInstance, ref *addrs.Reference) (addrs.ModuleInstance, []*addrs.Reference) {
+	key := metaReferencesKey(moduleAddr, ref)
+	if f.metaReferenceCalls == nil {
+		f.metaReferenceCalls = make(map[string]int)
+	}
+	f.metaReferenceCalls[key]++
+
+	result, ok := f.metaReferences[key]
+	if !ok {
+		return moduleAddr, nil
+	}
+	return result.moduleAddr, result.refs
+}
+
+func (f *fakeResolver) ModuleCallInstanceSelectorReferences(callerAddr addrs.ModuleInstance, callName string) []*addrs.Reference {
+	key := fmt.Sprintf("%s|%s", callerAddr.String(), callName)
+	return f.selectorReferences[key]
+}
+
+func TestReferencesFromOutputs_CyclicLocalsTerminate(t *testing.T) {
+	refA := &addrs.Reference{Subject: addrs.LocalValue{Name: "a"}}
+	refB := &addrs.Reference{Subject: addrs.LocalValue{Name: "b"}}
+	refX := &addrs.Reference{Subject: addrs.ResourceInstance{
+		Resource: addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_thing", Name: "x"},
+		Key:      addrs.NoKey,
+	}}
+
+	root := addrs.RootModuleInstance
+	resolver := &fakeResolver{
+		metaReferences: map[string]fakeMetaReferencesResult{
+			metaReferencesKey(root, refA): {moduleAddr: root, refs: []*addrs.Reference{refB}},
+			// local.b refers back to local.a (closing the cycle) as well as
+			// to a resource instance, so we can confirm the walk both
+			// terminates and still reports the reachable resource.
+			metaReferencesKey(root, refB): {moduleAddr: root, refs: []*addrs.Reference{refA, refX}},
+			metaReferencesKey(root, refX): {moduleAddr: root, refs: nil},
+		},
+	}
+
+	got := referencesFromOutputs(resolver, root, []*addrs.Reference{refA}, DefaultMaxReferenceChainDepth)
+
+	if len(got) != 1 {
+		t.Fatalf("got %d reference chains, want 1: %#v", len(got), got)
+	}
+	if got[0].Target.String() != refX.Subject.String() {
+		t.Errorf("got target %s, want %s", got[0].Target.String(), refX.Subject.String())
+	}
+	if got[0].TargetModule.String() != root.String() {
+		t.Errorf("got target module %s, want root", got[0].TargetModule.String())
+	}
+
+	// The cycle must have been detected rather than expanded repeatedly:
+	// local.a's references are only ever looked up once, even though it's
+	// reachable a second time via local.b.
+	key := metaReferencesKey(root, refA)
+	if calls := resolver.metaReferenceCalls[key]; calls != 1 {
+		t.Errorf("MetaReferences was called %d times for local.a, want exactly 1 (cycle not detected)", calls)
+	}
+}
+
+func TestReferencesFromOutputs_ModuleCallSelectorScopedToCaller(t *testing.T) {
+	root := addrs.RootModuleInstance
+	calleeAddr := root.Child("child", addrs.NoKey)
+
+	refOut := &addrs.Reference{Subject: addrs.ModuleCallInstanceOutput{
+		Call: addrs.ModuleCallInstance{
+			Call: addrs.ModuleCall{Name: "child"},
+			Key:  addrs.NoKey,
+		},
+		Name: "out",
+	}}
+	refCalleeResource := &addrs.Reference{Subject: addrs.ResourceInstance{
+		Resource: addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_thing", Name: "y"},
+		Key:      addrs.NoKey,
+	}}
+	refCallerVar := &addrs.Reference{Subject: addrs.InputVariable{Name: "count_src"}}
+
+	resolver := &fakeResolver{
+		metaReferences: map[string]fakeMetaReferencesResult{
+			// The output's own expression refers to something inside the
+			// callee, so MetaReferences hands back the callee's module
+			// instance alongside it.
+			metaReferencesKey(root, refOut):                  {moduleAddr: calleeAddr, refs: []*addrs.Reference{refCalleeResource}},
+			metaReferencesKey(calleeAddr, refCalleeResource): {moduleAddr: calleeAddr, refs: nil},
+			metaReferencesKey(root, refCallerVar):            {moduleAddr: root, refs: nil},
+		},
+		selectorReferences: map[string][]*addrs.Reference{
+			// The module call's own "count"/"for_each" expression lives in
+			// the caller (root), not the callee.
+			root.String() + "|child": {refCallerVar},
+		},
+	}
+
+	got := referencesFromOutputs(resolver, root, []*addrs.Reference{refOut}, DefaultMaxReferenceChainDepth)
+
+	if len(got) != 2 {
+		t.Fatalf("got %d reference chains, want 2: %#v", len(got), got)
+	}
+
+	var sawCalleeResource, sawCallerVar bool
+	for _, chain := range got {
+		switch chain.Target.String() {
+		case refCalleeResource.Subject.String():
+			sawCalleeResource = true
+			if chain.TargetModule.String() != calleeAddr.String() {
+				t.Errorf("resource instance target module = %s, want callee %s", chain.TargetModule.String(), calleeAddr.String())
+			}
+		case refCallerVar.Subject.String():
+			sawCallerVar = true
+			if chain.TargetModule.String() != root.String() {
+				t.Errorf("count/for_each var target module = %s, want caller (root), not the callee", chain.TargetModule.String())
+			}
+		}
+	}
+	if !sawCalleeResource {
+		t.Error("expected a reference chain reaching the callee's resource instance")
+	}
+	if !sawCallerVar {
+		t.Error("expected a reference chain reaching the caller's count/for_each variable")
+	}
+}
+
+func TestReferencesFromOutputs_DeduplicatesAcrossMultiplePaths(t *testing.T) {
+	root := addrs.RootModuleInstance
+	refA := &addrs.Reference{Subject: addrs.LocalValue{Name: "a"}}
+	refB := &addrs.Reference{Subject: addrs.LocalValue{Name: "b"}}
+	refX := &addrs.Reference{Subject: addrs.ResourceInstance{
+		Resource: addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "test_thing", Name: "x"},
+		Key:      addrs.NoKey,
+	}}
+
+	resolver := &fakeResolver{
+		metaReferences: map[string]fakeMetaReferencesResult{
+			metaReferencesKey(root, refA): {moduleAddr: root, refs: []*addrs.Reference{refX}},
+			metaReferencesKey(root, refB): {moduleAddr: root, refs: []*addrs.Reference{refX}},
+			metaReferencesKey(root, refX): {moduleAddr: root, refs: nil},
+		},
+	}
+
+	// Both root refs reach the same resource instance; it must be reported
+	// only once even though it's reachable by two different paths.
+	got := referencesFromOutputs(resolver, root, []*addrs.Reference{refA, refB}, DefaultMaxReferenceChainDepth)
+
+	if len(got) != 1 {
+		t.Fatalf("got %d reference chains, want 1 (deduplicated): %#v", len(got), got)
+	}
+}
@@ -0,0 +1,100 @@
+package globalref
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+// zeroRangeTraversal returns a traversal whose steps all carry the HCL zero
+// value SourceRange, simulating a body that doesn't carry meaningful byte
+// ranges (such as one assembled from JSON-syntax config, or -- as with
+// fakeBody in analyzer_meta_references_test.go -- one that was never parsed
+// from source text at all).
+func zeroRangeTraversal(names ...string) hcl.Traversal {
+	return refTraversal(names...)
+}
+
+func TestTraversalKey(t *testing.T) {
+	a := zeroRangeTraversal("var", "a")
+	b := zeroRangeTraversal("var", "b")
+	aAgain := zeroRangeTraversal("var", "a")
+
+	if traversalKey(a) == traversalKey(b) {
+		t.Errorf("traversalKey(%v) == traversalKey(%v), want different keys", a, b)
+	}
+	if traversalKey(a) != traversalKey(aAgain) {
+		t.Errorf("traversalKey(%v) != traversalKey(%v), want the same key", a, aAgain)
+	}
+
+	// The common case: a bare reference like "var.x" has no Remaining
+	// traversal at all, and its key is the empty string; visitKey's module
+	// and subject parts are what tell two such bare references apart.
+	if got := traversalKey(nil); got != "" {
+		t.Errorf("traversalKey(nil) = %q, want empty string", got)
+	}
+
+	indexed := hcl.Traversal{
+		hcl.TraverseAttr{Name: "list_item"},
+		hcl.TraverseIndex{Key: cty.NumberIntVal(0)},
+	}
+	indexedOther := hcl.Traversal{
+		hcl.TraverseAttr{Name: "list_item"},
+		hcl.TraverseIndex{Key: cty.NumberIntVal(1)},
+	}
+	if traversalKey(indexed) == traversalKey(indexedOther) {
+		t.Errorf("different index keys produced the same traversalKey %q", traversalKey(indexed))
+	}
+}
+
+func TestReferenceChainFrontierVisitKey(t *testing.T) {
+	moduleAddr := addrs.RootModuleInstance
+
+	// Two references to the same subject but with different remaining
+	// traversals -- both of which carry the zero-value hcl.Range, as a
+	// body assembled without real source positions would produce -- must
+	// be treated as distinct for cycle-detection purposes, not collapsed
+	// together because their (now-unused) source ranges happened to
+	// coincide.
+	subject := addrs.ResourceInstance{
+		Resource: addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "test_thing",
+			Name: "foo",
+		},
+		Key: addrs.NoKey,
+	}
+
+	entryA := &referenceChainFrontier{
+		moduleAddr: moduleAddr,
+		ref: &addrs.Reference{
+			Subject:   subject,
+			Remaining: zeroRangeTraversal("a"),
+		},
+	}
+	entryB := &referenceChainFrontier{
+		moduleAddr: moduleAddr,
+		ref: &addrs.Reference{
+			Subject:   subject,
+			Remaining: zeroRangeTraversal("b"),
+		},
+	}
+
+	if entryA.visitKey() == entryB.visitKey() {
+		t.Errorf("visitKey collapsed two different remaining traversals onto the same key %q", entryA.visitKey())
+	}
+
+	entryAAgain := &referenceChainFrontier{
+		moduleAddr: moduleAddr,
+		ref: &addrs.Reference{
+			Subject:   subject,
+			Remaining: zeroRangeTraversal("a"),
+		},
+	}
+	if entryA.visitKey() != entryAAgain.visitKey() {
+		t.Errorf("visitKey gave different keys for equivalent references: %q vs %q", entryA.visitKey(), entryAAgain.visitKey())
+	}
+}